@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	defaultChunkSize = 16 * 1024 * 1024 // 16 MiB
+	maxChunkRetries  = 5
+)
+
+// Downloader fetches Drive files in resumable byte-range chunks, verifying
+// the result against Drive's md5Checksum before it is handed off to a
+// Storage sink. Google-native files (Docs/Sheets/Slides/Drawings) are
+// exported instead, per exportFormats.
+type Downloader struct {
+	srv           *drive.Service
+	chunkSize     int64
+	stagingDir    string
+	exportFormats map[string]string
+}
+
+// NewDownloader returns a Downloader that pulls file content in chunks of
+// chunkSize bytes, staging partial downloads under stagingDir so they can be
+// resumed across process restarts. A chunkSize <= 0 falls back to
+// defaultChunkSize. exportFormats maps a Google-native "kind" (e.g.
+// "document") to the mimeType it should be exported as.
+func NewDownloader(srv *drive.Service, chunkSize int64, stagingDir string, exportFormats map[string]string) *Downloader {
+	if chunkSize <= 0 {
+		chunkSize = defaultChunkSize
+	}
+	return &Downloader{srv: srv, chunkSize: chunkSize, stagingDir: stagingDir, exportFormats: exportFormats}
+}
+
+// ResolvedName returns the name f will actually be stored under: Google-
+// native files gain the export format's extension, everything else keeps
+// name as-is.
+func (d *Downloader) ResolvedName(f *drive.File, name string) string {
+	return resolveExportName(f, name, d.exportFormats)
+}
+
+// DownloadOrExport fetches f into storage under name: Google-native files
+// are exported per d.exportFormats, everything else is fetched via Download.
+// skipped is true when f's kind has no configured export format (e.g. a
+// Form), in which case the caller should treat it as intentionally skipped
+// rather than failed.
+func (d *Downloader) DownloadOrExport(f *drive.File, storage Storage, name string) (skipped bool, err error) {
+	kind, isGoogleNative := googleAppsKind(f.MimeType)
+	if !isGoogleNative {
+		return false, d.Download(f, storage, name)
+	}
+
+	exportMime, configured := d.exportFormats[kind]
+	if !configured {
+		log.Println(">> WARN: No export format configured for", kind, "- skipping", f.Name)
+		return true, nil
+	}
+
+	resp, err := d.srv.Files.Export(f.Id, exportMime).Download()
+	if err != nil {
+		return false, fmt.Errorf("unable to export %s as %s: %v", f.Name, exportMime, err)
+	}
+	defer resp.Body.Close()
+
+	exportedName := name + "." + exportExtension(exportMime)
+
+	var modTime time.Time
+	if t, err := time.Parse(time.RFC3339Nano, f.ModifiedTime); err == nil {
+		modTime = t
+	} else {
+		log.Println(">> WARN: Failed to parse modified time", f.Name)
+	}
+
+	if err := storage.Put(exportedName, resp.Body, modTime); err != nil {
+		return false, fmt.Errorf("unable to store exported %s: %v", f.Name, err)
+	}
+
+	return false, nil
+}
+
+// Download fetches f and puts it into storage under name, resuming from a
+// staged ".part" file if a previous attempt left one behind, and verifies
+// the md5Checksum Drive reports for the file before handing it to storage.
+func (d *Downloader) Download(f *drive.File, storage Storage, name string) error {
+	if err := os.MkdirAll(d.stagingDir, 0755); err != nil {
+		return fmt.Errorf("unable to create staging dir %s: %v", d.stagingDir, err)
+	}
+
+	partPath := filepath.Join(d.stagingDir, cleanFilename(name)+".part")
+
+	out, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to open part file %s: %v", partPath, err)
+	}
+
+	start, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		out.Close()
+		return fmt.Errorf("unable to seek part file %s: %v", partPath, err)
+	}
+
+	if start > f.Size {
+		// Stale part file from a different revision of the file, start over.
+		log.Println(">> Part file is larger than remote file, restarting download", partPath)
+		out.Close()
+		if err := os.Truncate(partPath, 0); err != nil {
+			return err
+		}
+		if out, err = os.OpenFile(partPath, os.O_WRONLY, 0644); err != nil {
+			return err
+		}
+		start = 0
+	}
+
+	for start < f.Size {
+		end := start + d.chunkSize - 1
+		if end > f.Size-1 {
+			end = f.Size - 1
+		}
+
+		log.Printf(">> Downloading bytes %d-%d of %d for %s\n", start, end, f.Size, f.Name)
+
+		n, err := d.downloadChunk(f, out, start, end)
+		if err != nil {
+			out.Close()
+			return fmt.Errorf("unable to download chunk %d-%d: %v", start, end, err)
+		}
+
+		start += n
+	}
+
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("unable to close part file %s: %v", partPath, err)
+	}
+
+	if f.Md5Checksum != "" {
+		sum, err := md5Sum(partPath)
+		if err != nil {
+			return fmt.Errorf("unable to checksum %s: %v", partPath, err)
+		}
+		if sum != f.Md5Checksum {
+			// Discard the part file so the next run starts the download over
+			// from scratch instead of re-verifying these same corrupt bytes
+			// forever: start would already equal f.Size next time around, so
+			// the resume logic above would never re-enter the download loop.
+			if rmErr := os.Remove(partPath); rmErr != nil {
+				log.Println(">> WARN: Failed to remove corrupt part file", partPath, rmErr)
+			}
+			return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, sum, f.Md5Checksum)
+		}
+	} else {
+		log.Println(">> WARN: Drive reported no md5Checksum, skipping verification", f.Name)
+	}
+
+	modTime, err := time.Parse(time.RFC3339Nano, f.ModifiedTime)
+	if err != nil {
+		log.Println(">> WARN: Failed to parse modified time", f.Name)
+	}
+
+	verified, err := os.Open(partPath)
+	if err != nil {
+		return fmt.Errorf("unable to reopen part file %s: %v", partPath, err)
+	}
+	defer verified.Close()
+
+	if err := storage.Put(name, verified, modTime); err != nil {
+		return err
+	}
+
+	if err := os.Remove(partPath); err != nil {
+		log.Println(">> WARN: Failed to remove staged part file", partPath)
+	}
+
+	return nil
+}
+
+// downloadChunk fetches the byte range [start, end] and appends it to out,
+// retrying with exponential backoff on 5xx/429 responses. It returns the
+// number of bytes written.
+func (d *Downloader) downloadChunk(f *drive.File, out *os.File, start, end int64) (int64, error) {
+	var lastErr error
+
+	for attempt := 0; attempt < maxChunkRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoffDelay(attempt, lastErr))
+		}
+
+		// Rewind to the start of this chunk before every attempt: a failed
+		// io.Copy on a previous attempt may have left partial bytes written
+		// at [start, start+n), and resuming from the current file position
+		// would append the retry's bytes after them instead of replacing them.
+		if _, err := out.Seek(start, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("unable to seek part file to %d: %v", start, err)
+		}
+
+		call := d.srv.Files.Get(f.Id)
+		call.Header().Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+		resp, err := call.Download()
+		if err != nil {
+			// Download reports non-2xx responses as a *googleapi.Error, so
+			// retryable statuses are told apart from fatal ones here.
+			var gerr *googleapi.Error
+			if errors.As(err, &gerr) {
+				if gerr.Code != http.StatusTooManyRequests && gerr.Code < 500 {
+					return 0, err
+				}
+				err = retryableStatusError{resp: &http.Response{
+					Status: fmt.Sprintf("%d %s", gerr.Code, http.StatusText(gerr.Code)),
+					Header: gerr.Header,
+				}}
+			}
+			lastErr = err
+			continue
+		}
+
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			lastErr = retryableStatusError{resp: resp}
+			resp.Body.Close()
+			continue
+		}
+
+		n, err := io.Copy(out, resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := out.Truncate(start + n); err != nil {
+			return 0, fmt.Errorf("unable to truncate part file to %d: %v", start+n, err)
+		}
+
+		return n, nil
+	}
+
+	return 0, lastErr
+}
+
+// retryableStatusError carries the response of a retryable (5xx/429) chunk
+// download so backoffDelay can honor a Retry-After header if present.
+type retryableStatusError struct {
+	resp *http.Response
+}
+
+func (e retryableStatusError) Error() string {
+	return fmt.Sprintf("retryable response: %s", e.resp.Status)
+}
+
+// backoffDelay computes how long to wait before the next retry attempt,
+// honoring a Retry-After header when lastErr carries one, and otherwise
+// falling back to exponential backoff with jitter.
+func backoffDelay(attempt int, lastErr error) time.Duration {
+	if rse, ok := lastErr.(retryableStatusError); ok {
+		if ra := rse.resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+
+	base := time.Duration(1<<uint(attempt)) * time.Second
+	jitter := time.Duration(rand.Int63n(int64(base) / 2))
+	return base + jitter
+}
+
+func md5Sum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
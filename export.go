@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+const googleAppsMimePrefix = "application/vnd.google-apps."
+
+// defaultExportFormats maps a Google-native file "kind" (the suffix of its
+// mimeType, e.g. "document") to the export mimeType used when none is
+// configured via --export.
+var defaultExportFormats = map[string]string{
+	"document":     "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"spreadsheet":  "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	"presentation": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+	"drawing":      "image/png",
+}
+
+// exportExtensions maps a handful of common export mimeTypes to the file
+// extension that should be appended to the exported file's name.
+var exportExtensions = map[string]string{
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document": "docx",
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":       "xlsx",
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": "pptx",
+	"application/pdf": "pdf",
+	"text/csv":        "csv",
+	"text/plain":      "txt",
+	"image/png":       "png",
+	"image/jpeg":      "jpg",
+}
+
+// parseExportFormats parses a flag value like
+// "document=application/pdf,spreadsheet=text/csv" into a kind->mimeType
+// map, falling back to defaultExportFormats for any kind not specified.
+func parseExportFormats(spec string) (map[string]string, error) {
+	formats := make(map[string]string, len(defaultExportFormats))
+	for kind, mimeType := range defaultExportFormats {
+		formats[kind] = mimeType
+	}
+
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return formats, nil
+	}
+
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid --export entry %q, want kind=mimeType", pair)
+		}
+
+		formats[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return formats, nil
+}
+
+// googleAppsKind returns the short Drive "kind" for a google-apps mimeType,
+// e.g. "document" for "application/vnd.google-apps.document", and false if
+// mimeType isn't a Google-native type at all.
+func googleAppsKind(mimeType string) (string, bool) {
+	if !strings.HasPrefix(mimeType, googleAppsMimePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(mimeType, googleAppsMimePrefix), true
+}
+
+// exportExtension returns the file extension to append for a given export
+// mimeType, falling back to its mime subtype when it isn't one of the
+// common types above.
+func exportExtension(mimeType string) string {
+	if ext, ok := exportExtensions[mimeType]; ok {
+		return ext
+	}
+	parts := strings.Split(mimeType, "/")
+	return parts[len(parts)-1]
+}
+
+// resolveExportName returns the name f is actually stored under: Google-
+// native files gain the export format's extension (e.g. "Report" becomes
+// "Report.docx"), everything else keeps name as-is. It's the single source
+// of truth shared by Downloader.ResolvedName and reconcile, so matching a
+// local file against its remote counterpart and deciding what name to write
+// a download under never disagree.
+func resolveExportName(f *drive.File, name string, exportFormats map[string]string) string {
+	kind, isGoogleNative := googleAppsKind(f.MimeType)
+	if !isGoogleNative {
+		return name
+	}
+	if exportMime, configured := exportFormats[kind]; configured {
+		return name + "." + exportExtension(exportMime)
+	}
+	return name
+}
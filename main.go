@@ -1,15 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
-	"time"
+	"strings"
 
 	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
@@ -17,35 +18,6 @@ import (
 	"google.golang.org/api/drive/v3"
 )
 
-// DownloadFile downloads the content of a given file object
-func DownloadFile(d *drive.Service, t http.RoundTripper, f *drive.File) (string, error) {
-	// t parameter should use an oauth.Transport
-	downloadURL := f.WebContentLink
-	if downloadURL == "" {
-		// If there is no downloadURL, there is no body
-		fmt.Printf("An error occurred: File is not downloadable")
-		return "", nil
-	}
-	req, err := http.NewRequest("GET", downloadURL, nil)
-	if err != nil {
-		fmt.Printf("An error occurred: %v\n", err)
-		return "", err
-	}
-	resp, err := t.RoundTrip(req)
-	// Make sure we close the Body later
-	defer resp.Body.Close()
-	if err != nil {
-		fmt.Printf("An error occurred: %v\n", err)
-		return "", err
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		fmt.Printf("An error occurred: %v\n", err)
-		return "", err
-	}
-	return string(body), nil
-}
-
 // Retrieve a token, saves the token, then returns the generated client.
 func getClient(config *oauth2.Config) *http.Client {
 	// The file token.json stores the user's access and refresh tokens, and is
@@ -60,62 +32,79 @@ func getClient(config *oauth2.Config) *http.Client {
 	return config.Client(context.Background(), tok)
 }
 
-// Request a token from the web, then returns the retrieved token.
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
-	fmt.Printf("Go to the following link in your browser then type the "+
-		"authorization code: \n%v\n", authURL)
-
-	var authCode string
-	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code %v", err)
-	}
+// getTokenFromWeb, tokenFromFile, and saveToken live in oauth_callback.go and
+// token_crypto.go respectively: the former drives the loopback/PKCE consent
+// flow, the latter reads and writes an encrypted token.json.
+
+var (
+	mode         = flag.String("mode", "pull", "sync direction: pull, push, or sync")
+	incremental  = flag.Bool("incremental", false, "pull only: use the Drive Changes API to sync incrementally after the first full run")
+	stateFile    = flag.String("state-file", "state.json", "path to the incremental sync state file, kept next to token.json")
+	dryRun       = flag.Bool("dry-run", false, "log planned actions without changing anything")
+	uploadChunk  = flag.Int64("upload-chunk-size-mib", 8, "chunk size, in MiB, used for resumable uploads")
+	sink         = flag.String("sink", "local", "storage backend to write downloaded files to: local or s3")
+	s3Bucket     = flag.String("s3-bucket", "", "s3: target bucket name (required when --sink=s3)")
+	s3Prefix     = flag.String("s3-prefix", "", "s3: key prefix to store objects under")
+	s3Region     = flag.String("s3-region", "us-east-1", "s3: bucket region")
+	s3Endpoint   = flag.String("s3-endpoint", "", "s3: custom endpoint, for S3-compatible services")
+	s3AccessKey  = flag.String("s3-access-key", "", "s3: access key id")
+	s3SecretKey  = flag.String("s3-secret-key", "", "s3: secret access key")
+	s3PathStyle  = flag.Bool("s3-path-style", false, "s3: use path-style addressing instead of virtual-hosted")
+	s3Multipart  = flag.Bool("s3-multipart", true, "s3: upload large files using multipart upload")
+	chunkSizeMiB = flag.Int64("chunk-size-mib", defaultChunkSize/(1024*1024), "chunk size, in MiB, used for resumable downloads")
+	export       = flag.String("export", "", "comma-separated kind=mimeType export formats for Google-native files, "+
+		"e.g. document=application/pdf,spreadsheet=text/csv (unset kinds fall back to docx/xlsx/pptx/png)")
+)
 
-	tok, err := config.Exchange(context.TODO(), authCode)
-	if err != nil {
-		log.Fatalf("Unable to retrieve token from web %v", err)
+func newStorage(targetDir string) (Storage, error) {
+	switch *sink {
+	case "", "local":
+		return NewLocalStorage(targetDir)
+	case "s3":
+		if *s3Bucket == "" {
+			return nil, fmt.Errorf("--s3-bucket is required when --sink=s3")
+		}
+		return NewS3Storage(S3Config{
+			Bucket:          *s3Bucket,
+			Prefix:          *s3Prefix,
+			Region:          *s3Region,
+			Endpoint:        *s3Endpoint,
+			AccessKeyID:     *s3AccessKey,
+			SecretAccessKey: *s3SecretKey,
+			PathStyle:       *s3PathStyle,
+			Multipart:       *s3Multipart,
+		})
+	default:
+		return nil, fmt.Errorf("unknown --sink %q, want local or s3", *sink)
 	}
-	return tok
 }
 
-// Retrieves a token from a local file.
-func tokenFromFile(file string) (*oauth2.Token, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
-	}
-	defer f.Close()
-	tok := &oauth2.Token{}
-	err = json.NewDecoder(f).Decode(tok)
-	return tok, err
-}
+func main() {
+	flag.Parse()
 
-// Saves a token to a file path.
-func saveToken(path string, token *oauth2.Token) {
-	fmt.Printf("Saving credential file to: %s\n", path)
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
-	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+	if flag.NArg() != 3 {
+		fmt.Println("Usage:", os.Args[0], "[flags] SRC_DIR TARGET_DIR CRED_FILE")
+		flag.PrintDefaults()
+		return
 	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
 
-func main() {
-	if len(os.Args) != 4 {
-		fmt.Println("Usage:", os.Args[0], "SRC_DIR", "TARGET_DIR", "CRED_FILE")
-		return
+	if *mode != "pull" && *mode != "push" && *mode != "sync" {
+		log.Fatalf("Unknown --mode %q, want pull, push, or sync", *mode)
 	}
 
-	srcDir := os.Args[1]
-	targetDir := os.Args[2]
-	credFile := os.Args[3]
+	if *sink != "" && *sink != "local" && *mode != "pull" {
+		// Push/sync read the local side straight off disk via
+		// filepath.Walk, bypassing the Storage interface entirely, so a
+		// non-local --sink would reconcile against a different local
+		// directory than the one it's supposedly syncing with.
+		log.Fatalf("--sink=%s is only supported with --mode=pull; push and sync always read/write the local directory directly", *sink)
+	}
 
-	log.Println("Arguments:", srcDir, targetDir, credFile)
+	srcDir := flag.Arg(0)
+	targetDir := flag.Arg(1)
+	credFile := flag.Arg(2)
 
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		log.Fatalf("Unable to create target dir: %v", err)
-	}
+	log.Println("Arguments:", srcDir, targetDir, credFile, "mode", *mode)
 
 	b, err := ioutil.ReadFile(credFile)
 	if err != nil {
@@ -123,7 +112,11 @@ func main() {
 	}
 
 	// If modifying these scopes, delete your previously saved token.json.
-	config, err := google.ConfigFromJSON(b, drive.DriveReadonlyScope)
+	scope := drive.DriveReadonlyScope
+	if *mode != "pull" {
+		scope = drive.DriveScope
+	}
+	config, err := google.ConfigFromJSON(b, scope)
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
@@ -134,100 +127,212 @@ func main() {
 		log.Fatalf("Unable to retrieve Drive client: %v", err)
 	}
 
-	folders, err := srv.Files.List().
-		Q(fmt.Sprintf("mimeType = 'application/vnd.google-apps.folder' and name = '%s'", srcDir)).
-		PageSize(1).
-		Fields("files(id, name)").Do()
-
+	folder, err := resolveFolderPath(srv, srcDir, *mode != "pull")
 	if err != nil {
-		log.Fatalf("Unable to retrieve folders: %v", err)
+		log.Fatalf("Unable to resolve Drive folder: %v", err)
 	}
+	log.Println("Found folder", folder.Name, folder.Id)
 
-	if len(folders.Files) == 0 {
-		log.Fatalf("No folders found.")
+	exportFormats, err := parseExportFormats(*export)
+	if err != nil {
+		log.Fatalf("Unable to parse --export: %v", err)
 	}
 
-	folder := folders.Files[0]
-	log.Println("Found folder", folder.Name, folder.Id)
+	switch *mode {
+	case "pull":
+		var pullErr error
+		if *incremental {
+			pullErr = runIncrementalPull(srv, folder, targetDir, *stateFile, exportFormats)
+		} else {
+			pullErr = runPull(srv, folder, targetDir, exportFormats)
+		}
+		if pullErr != nil {
+			log.Fatalf("Pull failed: %v", pullErr)
+		}
+	case "push":
+		if err := runPush(srv, folder, targetDir); err != nil {
+			log.Fatalf("Push failed: %v", err)
+		}
+	case "sync":
+		if err := runSync(srv, folder, targetDir, exportFormats); err != nil {
+			log.Fatalf("Sync failed: %v", err)
+		}
+	}
+}
 
-	files, err := srv.Files.List().
-		Q(fmt.Sprintf("'%s' in parents", folder.Id)).
-		PageSize(25).
-		OrderBy("createdTime desc").
-		Fields("files(id, name, modifiedTime)").
-		Do()
+func tempStagingDir() string {
+	return filepath.Join(os.TempDir(), "gdrive-sync-staging")
+}
 
+func runPull(srv *drive.Service, folder *drive.File, targetDir string, exportFormats map[string]string) error {
+	storage, err := newStorage(targetDir)
 	if err != nil {
-		log.Fatalf("Unable to retrieve files: %v", err)
+		return fmt.Errorf("unable to set up storage: %v", err)
 	}
+	log.Println("Using storage sink", storage.Type())
 
-	if len(files.Files) == 0 {
-		log.Fatalf("No files found.")
+	files, _, err := walkFolder(srv, folder)
+	if err != nil {
+		return err
 	}
 
-	for _, f := range files.Files {
-		log.Println("Found file", f.Name, f.Id, f.CreatedTime)
+	if len(files) == 0 {
+		log.Println("No files found.")
+		return nil
+	}
 
-		outName := targetDir + "/" + cleanFilename(f.Name)
+	downloader := NewDownloader(srv, *chunkSizeMiB*1024*1024, tempStagingDir(), exportFormats)
 
-		log.Println(">> Outfile name is", outName)
+	for _, df := range files {
+		f := df.file
+		log.Println("Found file", f.Name, f.Id, f.CreatedTime)
 
-		if fileExists(outName) {
-			log.Println(">> File already exists", outName)
+		skipped, err := pullOne(downloader, storage, df)
+		if err != nil {
+			log.Println(">> Failed to download", f.Name, err)
+			continue
+		}
+		if skipped {
 			continue
 		}
 
-		log.Println(">> Downloading to", outName)
+		log.Println(">> Storing as file OK")
+	}
 
-		resp, err := srv.Files.Get(f.Id).Download()
+	return nil
+}
 
-		if err != nil {
-			log.Println(">> Failed to download")
-			continue
-		}
+func runPush(srv *drive.Service, folder *drive.File, localDir string) error {
+	local, err := listLocalFiles(localDir)
+	if err != nil {
+		return err
+	}
+
+	remoteFiles, _, err := walkFolder(srv, folder)
+	if err != nil {
+		return err
+	}
+	remoteByName := make(map[string]*drive.File, len(remoteFiles))
+	for _, df := range remoteFiles {
+		remoteByName[storageName(df.relDir, df.file.Name)] = df.file
+	}
 
-		log.Println(">> Download response OK")
+	uploader := NewUploader(srv, *uploadChunk*1024*1024, *dryRun)
 
-		out, err := os.Create(outName)
+	for name, lf := range local {
+		existing := remoteByName[name]
+
+		if existing != nil {
+			action, err := decide(lf, existing)
+			if err != nil {
+				return err
+			}
+			if action != actionPush {
+				log.Println(">> Skipping", name, "(remote is up to date)")
+				continue
+			}
+		}
 
+		parentID, err := uploader.EnsureFolderPath(folder.Id, path.Dir(name))
 		if err != nil {
-			log.Println(">> Failed to create filename", err, outName)
+			log.Println(">> Failed to create parent folder for", name, err)
+			continue
+		}
 
-			resp.Body.Close()
+		log.Println(">> Pushing", lf.path, "to Drive folder", folder.Name)
+
+		if _, err := uploader.Upload(lf.path, parentID, path.Base(name), existing); err != nil {
+			log.Println(">> Failed to push", name, err)
 			continue
 		}
 
-		io.Copy(out, resp.Body)
+		log.Println(">> Pushed", name, "OK")
+	}
 
-		resp.Body.Close()
-		out.Close()
+	return nil
+}
 
-		log.Println("mtime", f.ModifiedTime)
+func runSync(srv *drive.Service, folder *drive.File, localDir string, exportFormats map[string]string) error {
+	remoteFiles, _, err := walkFolder(srv, folder)
+	if err != nil {
+		return err
+	}
 
-		t, err := time.Parse(time.RFC3339Nano, f.ModifiedTime)
+	plans, err := reconcile(localDir, remoteFiles, exportFormats)
+	if err != nil {
+		return err
+	}
 
-		if err != nil {
-			log.Println(">> WARN: Failed to parse modified time")
-		} else {
-			if err := os.Chtimes(outName, t, t); err != nil {
-				log.Println(">> WARN: Failed to change creation time")
-			}
-		}
+	if len(plans) == 0 {
+		log.Println("Nothing to sync, local and remote already match.")
+		return nil
+	}
 
-		log.Println(">> Storing as file OK")
+	storage, err := newStorage(localDir)
+	if err != nil {
+		return fmt.Errorf("unable to set up storage: %v", err)
 	}
-}
 
-func fileExists(filename string) bool {
-	info, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		return false
+	stagingDir := tempStagingDir()
+	downloader := NewDownloader(srv, *chunkSizeMiB*1024*1024, stagingDir, exportFormats)
+	uploader := NewUploader(srv, *uploadChunk*1024*1024, *dryRun)
+
+	for _, p := range plans {
+		if *dryRun {
+			log.Println(">> [dry-run] Would", p.action, p.name)
+			continue
+		}
+
+		switch p.action {
+		case actionPush:
+			parentID, err := uploader.EnsureFolderPath(folder.Id, path.Dir(p.name))
+			if err != nil {
+				log.Println(">> Failed to create parent folder for", p.name, err)
+				continue
+			}
+			if _, err := uploader.Upload(p.local.path, parentID, path.Base(p.name), p.remote); err != nil {
+				log.Println(">> Failed to push", p.name, err)
+				continue
+			}
+			log.Println(">> Pushed", p.name, "OK")
+		case actionPull:
+			// p.name is export-resolved (e.g. "Report.docx"), and
+			// DownloadOrExport appends the export extension itself.
+			name := strings.TrimSuffix(p.name, downloader.ResolvedName(p.remote, ""))
+			if _, err := downloader.DownloadOrExport(p.remote, storage, name); err != nil {
+				log.Println(">> Failed to pull", p.name, err)
+				continue
+			}
+			log.Println(">> Pulled", p.name, "OK")
+		}
 	}
-	return !info.IsDir()
+
+	return nil
 }
 
 var re = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
 
+// cleanFilename sanitizes a single Drive file or folder name for use as a
+// path segment. Besides replacing punctuation that's unsafe in a path, it
+// also guards against "." and ".." (trivially creatable on a shared Drive),
+// which would otherwise survive as literal path segments and let a crafted
+// folder name escape the target directory when walkFolder joins them.
 func cleanFilename(in string) string {
-	return re.ReplaceAllString(in, "_")
+	cleaned := re.ReplaceAllString(in, "_")
+	if isDotsOnly(cleaned) {
+		return strings.Repeat("_", len(cleaned))
+	}
+	return cleaned
+}
+
+func isDotsOnly(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r != '.' {
+			return false
+		}
+	}
+	return true
 }
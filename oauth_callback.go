@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os/exec"
+	"runtime"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const oauthCallbackTimeout = 5 * time.Minute
+
+// getTokenFromWeb runs the standard installed-app OAuth flow: it starts a
+// loopback HTTP server, opens the consent screen in the user's browser, and
+// exchanges the authorization code Google redirects back with for a token.
+// PKCE (RFC 7636, S256) is used so the authorization code can't be replayed
+// by anything that doesn't also hold the original code_verifier.
+func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatalf("Unable to start local OAuth callback listener: %v", err)
+	}
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	config.RedirectURL = fmt.Sprintf("http://127.0.0.1:%d/callback", port)
+
+	state, err := randomURLSafeString(16)
+	if err != nil {
+		log.Fatalf("Unable to generate OAuth state: %v", err)
+	}
+
+	verifier, err := randomURLSafeString(32)
+	if err != nil {
+		log.Fatalf("Unable to generate PKCE code verifier: %v", err)
+	}
+
+	authURL := config.AuthCodeURL(state,
+		oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	code, err := awaitOAuthCallback(listener, state, authURL)
+	if err != nil {
+		log.Fatalf("OAuth callback failed: %v", err)
+	}
+
+	tok, err := config.Exchange(context.TODO(), code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Fatalf("Unable to retrieve token from web: %v", err)
+	}
+
+	return tok
+}
+
+// awaitOAuthCallback serves a single request on /callback, verifying state
+// matches what we sent, and returns the authorization code.
+func awaitOAuthCallback(listener net.Listener, state, authURL string) (string, error) {
+	type callbackResult struct {
+		code string
+		err  error
+	}
+	resultCh := make(chan callbackResult, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		if reason := q.Get("error"); reason != "" {
+			resultCh <- callbackResult{err: fmt.Errorf("authorization denied: %s", reason)}
+			http.Error(w, "Authorization denied, you can close this tab.", http.StatusForbidden)
+			return
+		}
+
+		if q.Get("state") != state {
+			resultCh <- callbackResult{err: fmt.Errorf("state mismatch in OAuth callback")}
+			http.Error(w, "State mismatch, you can close this tab.", http.StatusBadRequest)
+			return
+		}
+
+		resultCh <- callbackResult{code: q.Get("code")}
+		fmt.Fprintln(w, "Authorization complete, you can close this tab.")
+	})
+
+	srv := &http.Server{Handler: mux}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	log.Println("Opening browser for authorization:", authURL)
+	if !openBrowser(authURL) {
+		fmt.Printf("Go to the following link in your browser:\n%v\n", authURL)
+	}
+
+	select {
+	case res := <-resultCh:
+		return res.code, res.err
+	case <-time.After(oauthCallbackTimeout):
+		return "", fmt.Errorf("timed out waiting for the browser to complete authorization")
+	}
+}
+
+func randomURLSafeString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// openBrowser tries to open url with the platform's default browser,
+// returning false if no suitable command could be started so the caller can
+// fall back to printing the URL.
+func openBrowser(url string) bool {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("cmd", "/c", "start", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+
+	return cmd.Start() == nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// S3Config carries the flags needed to talk to an S3-compatible bucket.
+type S3Config struct {
+	Bucket          string
+	Prefix          string
+	Region          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	PathStyle       bool
+	Multipart       bool
+}
+
+// S3Storage stores files as objects in an S3-compatible bucket.
+type S3Storage struct {
+	cfg      S3Config
+	s3       *s3.S3
+	uploader *s3manager.Uploader
+}
+
+// NewS3Storage builds an S3Storage from cfg.
+func NewS3Storage(cfg S3Config) (*S3Storage, error) {
+	awsCfg := aws.NewConfig().
+		WithRegion(cfg.Region).
+		WithS3ForcePathStyle(cfg.PathStyle)
+
+	if cfg.Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.Endpoint)
+	}
+
+	if cfg.AccessKeyID != "" || cfg.SecretAccessKey != "" {
+		awsCfg = awsCfg.WithCredentials(credentials.NewStaticCredentials(cfg.AccessKeyID, cfg.SecretAccessKey, ""))
+	}
+
+	sess, err := session.NewSession(awsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create S3 session: %v", err)
+	}
+
+	uploader := s3manager.NewUploader(sess)
+	if !cfg.Multipart {
+		// A part size larger than any realistic Drive file disables chunking.
+		uploader.PartSize = 5 * 1024 * 1024 * 1024 * 1024
+	}
+
+	return &S3Storage{cfg: cfg, s3: s3.New(sess), uploader: uploader}, nil
+}
+
+func (s *S3Storage) key(name string) string {
+	if s.cfg.Prefix == "" {
+		return name
+	}
+	return s.cfg.Prefix + "/" + name
+}
+
+func (s *S3Storage) Put(name string, r io.Reader, modTime time.Time) error {
+	key := s.key(name)
+
+	_, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+		Body:   r,
+		Metadata: map[string]*string{
+			"mtime": aws.String(modTime.Format(time.RFC3339Nano)),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("unable to upload %s to s3://%s/%s: %v", name, s.cfg.Bucket, key, err)
+	}
+
+	return nil
+}
+
+func (s *S3Storage) Exists(name string) (bool, error) {
+	_, _, err := s.Head(name)
+	if err != nil {
+		if isS3NotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (s *S3Storage) Head(name string) (int64, time.Time, error) {
+	key := s.key(name)
+
+	out, err := s.s3.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	size := int64(0)
+	if out.ContentLength != nil {
+		size = *out.ContentLength
+	}
+
+	modTime := time.Time{}
+	if mt, ok := out.Metadata["Mtime"]; ok && mt != nil {
+		if parsed, err := time.Parse(time.RFC3339Nano, *mt); err == nil {
+			modTime = parsed
+		}
+	}
+
+	return size, modTime, nil
+}
+
+func (s *S3Storage) Type() string {
+	return "s3"
+}
+
+func isS3NotFound(err error) bool {
+	if aerr, ok := err.(awsErrorCode); ok {
+		code := aerr.Code()
+		return code == s3.ErrCodeNoSuchKey || code == "NotFound"
+	}
+	return false
+}
+
+// awsErrorCode is the subset of awserr.Error that isS3NotFound needs.
+type awsErrorCode interface {
+	Code() string
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage is a sink that downloaded Drive files are written to. It is
+// implemented by LocalStorage and S3Storage, selected at runtime via the
+// --sink flag.
+type Storage interface {
+	// Put writes r to name, setting modTime on the stored object where the
+	// backend supports it.
+	Put(name string, r io.Reader, modTime time.Time) error
+	// Exists reports whether name is already present in the sink.
+	Exists(name string) (bool, error)
+	// Head returns the size and modification time of name.
+	Head(name string) (size int64, modTime time.Time, err error)
+	// Type identifies the backend, e.g. for logging.
+	Type() string
+}
+
+// LocalStorage stores files under a directory on the local filesystem.
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage returns a LocalStorage rooted at dir, creating it if
+// necessary.
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("unable to create target dir: %v", err)
+	}
+	return &LocalStorage{Dir: dir}, nil
+}
+
+// path joins name onto s.Dir and verifies the result is still contained
+// within s.Dir, guarding against a name that smuggles a ".." segment (e.g.
+// from a maliciously named Drive folder) and would otherwise let Put write
+// outside the target directory.
+func (s *LocalStorage) path(name string) (string, error) {
+	joined := filepath.Join(s.Dir, name)
+
+	root, err := filepath.Abs(s.Dir)
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(joined)
+	if err != nil {
+		return "", err
+	}
+	if abs != root && !strings.HasPrefix(abs, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to use %q: escapes target directory %q", name, s.Dir)
+	}
+
+	return joined, nil
+}
+
+func (s *LocalStorage) Put(name string, r io.Reader, modTime time.Time) error {
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("unable to create parent dir for %s: %v", path, err)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %v", path, err)
+	}
+
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return fmt.Errorf("unable to write %s: %v", path, err)
+	}
+
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	if !modTime.IsZero() {
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			return fmt.Errorf("unable to set mtime on %s: %v", path, err)
+		}
+	}
+
+	return nil
+}
+
+func (s *LocalStorage) Exists(name string) (bool, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return false, err
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return !info.IsDir(), nil
+}
+
+func (s *LocalStorage) Head(name string) (int64, time.Time, error) {
+	path, err := s.path(name)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+func (s *LocalStorage) Type() string {
+	return "local"
+}
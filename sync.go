@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// syncAction describes what reconcile decided to do with a single name.
+type syncAction int
+
+const (
+	actionNone syncAction = iota
+	actionPush
+	actionPull
+)
+
+func (a syncAction) String() string {
+	switch a {
+	case actionPush:
+		return "push"
+	case actionPull:
+		return "pull"
+	default:
+		return "none"
+	}
+}
+
+// syncPlan is one reconciled name and the action to take for it.
+type syncPlan struct {
+	name   string
+	action syncAction
+	local  *localFile
+	remote *drive.File
+}
+
+type localFile struct {
+	path    string
+	info    os.FileInfo
+	md5     string
+}
+
+// listLocalFiles recursively walks dir and returns every regular file found,
+// keyed by its slash-separated path relative to dir with each segment
+// cleaned via cleanFilename, so names line up with the paths walkFolder
+// produces for the remote side and nested directories mirror correctly.
+func listLocalFiles(dir string) (map[string]*localFile, error) {
+	files := make(map[string]*localFile)
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir || info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		relDir := filepath.ToSlash(filepath.Dir(rel))
+		if relDir == "." {
+			relDir = ""
+		} else {
+			segments := strings.Split(relDir, "/")
+			for i, s := range segments {
+				segments[i] = cleanFilename(s)
+			}
+			relDir = strings.Join(segments, "/")
+		}
+
+		files[storageName(relDir, info.Name())] = &localFile{path: path, info: info}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list local dir %s: %v", dir, err)
+	}
+
+	return files, nil
+}
+
+// reconcile compares the local files under localDir against the remote
+// files in a Drive folder's subtree and decides, per name, whether the
+// local or the remote copy should win, preferring whichever side was
+// modified more recently and skipping names whose md5Checksum already
+// matches. remoteByName is keyed by each remote file's export-resolved name
+// (see resolveExportName) so a previously-exported Google-native file (e.g.
+// a Doc stored locally as "Report.docx") matches its remote "Report" entry
+// instead of being mistaken for an unrelated local-only file.
+func reconcile(localDir string, remoteFiles []driveFile, exportFormats map[string]string) ([]syncPlan, error) {
+	local, err := listLocalFiles(localDir)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteByName := make(map[string]*drive.File, len(remoteFiles))
+	for _, df := range remoteFiles {
+		name := storageName(df.relDir, df.file.Name)
+		remoteByName[resolveExportName(df.file, name, exportFormats)] = df.file
+	}
+
+	seen := make(map[string]bool)
+	var plans []syncPlan
+
+	for name, lf := range local {
+		r := remoteByName[name]
+		seen[name] = true
+
+		if r == nil {
+			plans = append(plans, syncPlan{name: name, action: actionPush, local: lf})
+			continue
+		}
+
+		action, err := decide(lf, r)
+		if err != nil {
+			return nil, err
+		}
+		if action != actionNone {
+			plans = append(plans, syncPlan{name: name, action: action, local: lf, remote: r})
+		}
+	}
+
+	for name, r := range remoteByName {
+		if seen[name] {
+			continue
+		}
+		plans = append(plans, syncPlan{name: name, action: actionPull, remote: r})
+	}
+
+	return plans, nil
+}
+
+// decide compares a local/remote pair already known to exist on both sides
+// and returns which side should win, or actionNone if they already match.
+func decide(lf *localFile, r *drive.File) (syncAction, error) {
+	remoteTime, err := time.Parse(time.RFC3339Nano, r.ModifiedTime)
+	if err != nil {
+		log.Println(">> WARN: Failed to parse remote modifiedTime for", r.Name)
+		remoteTime = time.Time{}
+	}
+
+	localTime := lf.info.ModTime()
+
+	if r.Md5Checksum != "" {
+		if lf.md5 == "" {
+			sum, err := md5Sum(lf.path)
+			if err != nil {
+				return actionNone, err
+			}
+			lf.md5 = sum
+		}
+		if lf.md5 == r.Md5Checksum {
+			return actionNone, nil
+		}
+	}
+
+	if localTime.After(remoteTime) {
+		return actionPush, nil
+	}
+	if remoteTime.After(localTime) {
+		return actionPull, nil
+	}
+
+	return actionNone, nil
+}
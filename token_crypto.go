@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+
+	"github.com/zalando/go-keyring"
+)
+
+const (
+	tokenPassphraseEnvVar = "GDRIVE_SYNC_PASSPHRASE"
+	keyringService        = "gdrive-sync"
+	keyringUser           = "token-passphrase"
+
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+// encryptedToken is the on-disk format of token.json: the token is encrypted
+// with AES-GCM using a key derived from the user's passphrase, so the file
+// is useless without it even though it's kept world-unreadable (0600) too.
+type encryptedToken struct {
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// tokenFromFile reads and decrypts a token previously written by saveToken.
+func tokenFromFile(file string) (*oauth2.Token, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var et encryptedToken
+	if err := json.NewDecoder(f).Decode(&et); err != nil {
+		return nil, fmt.Errorf("unable to parse encrypted token file %s: %v", file, err)
+	}
+
+	passphrase, err := tokenPassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := decryptBytes(et, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decrypt token file %s: %v", file, err)
+	}
+
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(plaintext, tok); err != nil {
+		return nil, fmt.Errorf("unable to parse decrypted token: %v", err)
+	}
+	return tok, nil
+}
+
+// saveToken encrypts tok and saves it to file with 0600 perms.
+func saveToken(file string, tok *oauth2.Token) {
+	fmt.Printf("Saving credential file to: %s\n", file)
+
+	passphrase, err := tokenPassphrase()
+	if err != nil {
+		fmt.Printf("Unable to obtain token passphrase: %v\n", err)
+		return
+	}
+
+	plaintext, err := json.Marshal(tok)
+	if err != nil {
+		fmt.Printf("Unable to marshal token: %v\n", err)
+		return
+	}
+
+	et, err := encryptBytes(plaintext, passphrase)
+	if err != nil {
+		fmt.Printf("Unable to encrypt token: %v\n", err)
+		return
+	}
+
+	f, err := os.OpenFile(file, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		fmt.Printf("Unable to cache oauth token: %v\n", err)
+		return
+	}
+	defer f.Close()
+
+	json.NewEncoder(f).Encode(et)
+}
+
+// tokenPassphrase resolves the passphrase used to encrypt/decrypt token.json:
+// the GDRIVE_SYNC_PASSPHRASE env var if set, otherwise the OS keyring,
+// generating and storing a new random passphrase there on first use.
+func tokenPassphrase() (string, error) {
+	if p := os.Getenv(tokenPassphraseEnvVar); p != "" {
+		return p, nil
+	}
+
+	p, err := keyring.Get(keyringService, keyringUser)
+	if err == nil {
+		return p, nil
+	}
+	if err != keyring.ErrNotFound {
+		return "", fmt.Errorf("unable to read passphrase from OS keyring: %v", err)
+	}
+
+	generated, err := randomURLSafeString(32)
+	if err != nil {
+		return "", fmt.Errorf("unable to generate passphrase: %v", err)
+	}
+
+	if err := keyring.Set(keyringService, keyringUser, generated); err != nil {
+		return "", fmt.Errorf("unable to store passphrase in OS keyring: %v", err)
+	}
+
+	return generated, nil
+}
+
+// deriveTokenKey derives a 32-byte AES-256 key from passphrase and salt using
+// scrypt, so brute-forcing the key requires far more than a single hash.
+func deriveTokenKey(passphrase string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+}
+
+func encryptBytes(plaintext []byte, passphrase string) (encryptedToken, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return encryptedToken{}, err
+	}
+
+	key, err := deriveTokenKey(passphrase, salt)
+	if err != nil {
+		return encryptedToken{}, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return encryptedToken{}, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return encryptedToken{}, err
+	}
+
+	ciphertext := gcm.Seal(nil, nonce, plaintext, nil)
+
+	return encryptedToken{Salt: salt, Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+func decryptBytes(et encryptedToken, passphrase string) ([]byte, error) {
+	key, err := deriveTokenKey(passphrase, et.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Open(nil, et.Nonce, et.Ciphertext, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+)
+
+const folderMimeType = "application/vnd.google-apps.folder"
+
+// Uploader pushes local files to Drive via resumable multipart uploads,
+// creating any missing parent folders along the way.
+type Uploader struct {
+	srv       *drive.Service
+	chunkSize int64
+	dryRun    bool
+}
+
+// NewUploader returns an Uploader that uploads file content in chunks of
+// chunkSize bytes. A chunkSize <= 0 falls back to googleapi's default.
+func NewUploader(srv *drive.Service, chunkSize int64, dryRun bool) *Uploader {
+	if chunkSize <= 0 {
+		chunkSize = googleapi.DefaultUploadChunkSize
+	}
+	return &Uploader{srv: srv, chunkSize: chunkSize, dryRun: dryRun}
+}
+
+// EnsureFolder returns the child folder named name under parentID, creating
+// it if it doesn't already exist.
+func (u *Uploader) EnsureFolder(parentID, name string) (*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType = '%s' and trashed = false",
+		parentID, name, folderMimeType)
+
+	res, err := u.srv.Files.List().Q(q).PageSize(1).Fields("files(id, name)").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up folder %s: %v", name, err)
+	}
+
+	if len(res.Files) > 0 {
+		return res.Files[0], nil
+	}
+
+	if u.dryRun {
+		log.Println(">> [dry-run] Would create folder", name, "under", parentID)
+		return &drive.File{Id: "", Name: name, MimeType: folderMimeType}, nil
+	}
+
+	folder, err := u.srv.Files.Create(&drive.File{
+		Name:     name,
+		MimeType: folderMimeType,
+		Parents:  []string{parentID},
+	}).Fields("id, name").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to create folder %s: %v", name, err)
+	}
+
+	return folder, nil
+}
+
+// EnsureFolderPath walks rel (a slash-separated path relative to the Drive
+// folder rooted at rootID), creating any missing folders, and returns the
+// id of the deepest folder.
+func (u *Uploader) EnsureFolderPath(rootID, rel string) (string, error) {
+	parentID := rootID
+
+	if rel == "" || rel == "." {
+		return parentID, nil
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if part == "" {
+			continue
+		}
+		folder, err := u.EnsureFolder(parentID, part)
+		if err != nil {
+			return "", err
+		}
+		parentID = folder.Id
+	}
+
+	return parentID, nil
+}
+
+// Upload creates or updates a file named name under parentID with the
+// content of localPath, using a resumable upload chunked at u.chunkSize.
+func (u *Uploader) Upload(localPath, parentID, name string, existing *drive.File) (*drive.File, error) {
+	if u.dryRun {
+		log.Println(">> [dry-run] Would upload", localPath, "as", name, "under", parentID)
+		return existing, nil
+	}
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %v", localPath, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat %s: %v", localPath, err)
+	}
+
+	mimeType := mimeTypeForName(name)
+	media := googleapi.ChunkSize(int(u.chunkSize))
+
+	var uploaded *drive.File
+	if existing != nil {
+		uploaded, err = u.srv.Files.Update(existing.Id, &drive.File{}).
+			Media(f, media).
+			Fields("id, name, modifiedTime, md5Checksum").Do()
+	} else {
+		uploaded, err = u.srv.Files.Create(&drive.File{
+			Name:     name,
+			Parents:  []string{parentID},
+			MimeType: mimeType,
+		}).Media(f, media).Fields("id, name, modifiedTime, md5Checksum").Do()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to upload %s: %v", localPath, err)
+	}
+
+	if !info.ModTime().IsZero() {
+		_, err := u.srv.Files.Update(uploaded.Id, &drive.File{
+			ModifiedTime: info.ModTime().UTC().Format(time.RFC3339Nano),
+		}).Do()
+		if err != nil {
+			log.Println(">> WARN: Failed to set modifiedTime on", name, err)
+		}
+	}
+
+	return uploaded, nil
+}
+
+func mimeTypeForName(name string) string {
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		return strings.Split(t, ";")[0]
+	}
+	return "application/octet-stream"
+}
+
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/drive/v3"
+)
+
+// driveFile pairs a Drive file with the slash-separated path of the folder
+// it was found in, relative to the root folder being synced.
+type driveFile struct {
+	relDir string
+	file   *drive.File
+}
+
+// resolveFolderPath resolves a "/"-separated path such as "A/B/C" to a
+// Drive folder, descending one path segment at a time starting from root
+// (Drive's alias for "My Drive"). When create is true, missing segments are
+// created as folders along the way.
+func resolveFolderPath(srv *drive.Service, path string, create bool) (*drive.File, error) {
+	parentID := "root"
+	var folder *drive.File
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for _, part := range parts {
+		if part == "" {
+			continue
+		}
+
+		found, err := findChildFolder(srv, parentID, part)
+		if err != nil {
+			return nil, err
+		}
+
+		if found == nil {
+			if !create {
+				return nil, fmt.Errorf("no folder named %q found under parent %s", part, parentID)
+			}
+			found, err = srv.Files.Create(&drive.File{
+				Name:     part,
+				MimeType: folderMimeType,
+				Parents:  []string{parentID},
+			}).Fields("id, name").Do()
+			if err != nil {
+				return nil, fmt.Errorf("unable to create folder %q: %v", part, err)
+			}
+		}
+
+		folder = found
+		parentID = found.Id
+	}
+
+	if folder == nil {
+		return nil, fmt.Errorf("empty folder path")
+	}
+
+	return folder, nil
+}
+
+func findChildFolder(srv *drive.Service, parentID, name string) (*drive.File, error) {
+	q := fmt.Sprintf("'%s' in parents and name = '%s' and mimeType = '%s' and trashed = false",
+		parentID, name, folderMimeType)
+
+	res, err := srv.Files.List().Q(q).PageSize(1).Fields("files(id, name)").Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to look up folder %q: %v", name, err)
+	}
+
+	if len(res.Files) == 0 {
+		return nil, nil
+	}
+
+	return res.Files[0], nil
+}
+
+// walkFolder recursively lists every non-folder file under root, paginating
+// through Files.List with PageToken and descending into every subfolder. It
+// returns each file alongside the slash-separated path of the folder it
+// lives in, relative to root.
+func walkFolder(srv *drive.Service, root *drive.File) ([]driveFile, []string, error) {
+	var files []driveFile
+	folderIDs := []string{root.Id}
+
+	var walk func(folderID, relDir string) error
+	walk = func(folderID, relDir string) error {
+		pageToken := ""
+		for {
+			call := srv.Files.List().
+				Q(fmt.Sprintf("'%s' in parents and trashed = false", folderID)).
+				PageSize(1000).
+				Fields("nextPageToken, files(id, name, mimeType, modifiedTime, size, md5Checksum)")
+
+			if pageToken != "" {
+				call = call.PageToken(pageToken)
+			}
+
+			res, err := call.Do()
+			if err != nil {
+				return fmt.Errorf("unable to list folder %s: %v", folderID, err)
+			}
+
+			for _, f := range res.Files {
+				if f.MimeType == folderMimeType {
+					folderIDs = append(folderIDs, f.Id)
+					subDir := cleanFilename(f.Name)
+					if relDir != "" {
+						subDir = relDir + "/" + subDir
+					}
+					if err := walk(f.Id, subDir); err != nil {
+						return err
+					}
+					continue
+				}
+				files = append(files, driveFile{relDir: relDir, file: f})
+			}
+
+			if res.NextPageToken == "" {
+				return nil
+			}
+			pageToken = res.NextPageToken
+		}
+	}
+
+	if err := walk(root.Id, ""); err != nil {
+		return nil, nil, err
+	}
+
+	return files, folderIDs, nil
+}
+
+// storageName returns the sink-relative name for a file found at relDir,
+// e.g. storageName("A/B", "notes.txt") == "A/B/notes.txt".
+func storageName(relDir, fileName string) string {
+	if relDir == "" {
+		return cleanFilename(fileName)
+	}
+	return relDir + "/" + cleanFilename(fileName)
+}